@@ -1,18 +1,25 @@
 package rules
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/oktal/infix/logging"
 
+	"github.com/fxamacker/cbor/v2"
+	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/oktal/infix/rules/output"
+	"github.com/oktal/infix/rules/progress"
 	"github.com/oktal/infix/storage"
 )
 
@@ -34,10 +41,91 @@ func formatTimestamp(unixNano int64, layout string) string {
 	if strings.EqualFold(layout, "RFC3339") {
 		return ts.Format(time.RFC3339)
 	}
+	if strings.EqualFold(layout, "human") {
+		return fmt.Sprintf("%s (%s)", humanTimeAgo(ts, time.Now()), ts.Format(time.RFC3339))
+	}
 
 	return ts.Format(layout)
 }
 
+// humanDuration renders d as the two largest non-zero units out of
+// years/weeks/days/hours/minutes/seconds, e.g. "3d4h" or "2w1d".
+func humanDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"y", 365 * 24 * time.Hour},
+		{"w", 7 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	var parts []string
+	for _, u := range units {
+		if d < u.unit {
+			continue
+		}
+		n := d / u.unit
+		d -= n * u.unit
+		parts = append(parts, fmt.Sprintf("%d%s", n, u.suffix))
+		if len(parts) == 2 {
+			break
+		}
+	}
+
+	if len(parts) == 0 {
+		return "0s"
+	}
+	return strings.Join(parts, "")
+}
+
+// humanTimeAgo renders t relative to now, e.g. "2w3d ago" or "4h ago".
+func humanTimeAgo(t, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return humanDuration(d) + " from now"
+	}
+	return humanDuration(d) + " ago"
+}
+
+// humanCount renders n using a K/M/B suffix once it grows past 1000, e.g.
+// "1.2M".
+func humanCount(n int) string {
+	f := float64(n)
+	switch {
+	case f >= 1e9:
+		return fmt.Sprintf("%.1fB", f/1e9)
+	case f >= 1e6:
+		return fmt.Sprintf("%.1fM", f/1e6)
+	case f >= 1e3:
+		return fmt.Sprintf("%.1fK", f/1e3)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+// humanBytes renders n bytes using a KiB/MiB/GiB/TiB suffix, e.g. "1.2GiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for next := n / unit; next >= unit; next /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func (f *textFormater) format(iow io.Writer, serie string, timestamp int64) error {
 	if f.withTimestamp {
 		fmt.Fprintf(iow, "%s: %s\n", serie, formatTimestamp(timestamp, f.timestampLayout))
@@ -80,24 +168,196 @@ func (f *jsonFormater) formatLine(iow io.Writer, data map[string]interface{}) er
 	return nil
 }
 
+// splitSerie splits a raw TSM series key into its measurement name and tags,
+// so it can be re-expressed in formats other than the raw composite key.
+func splitSerie(serie string) (string, models.Tags) {
+	name, tags := models.ParseKey([]byte(serie))
+	return name, tags
+}
+
+// lineFormater renders a detected old serie as an InfluxDB line protocol
+// point, with a synthetic `_old=true` field and the detected timestamp as
+// the point's timestamp.
+type lineFormater struct {
+}
+
+func (f *lineFormater) format(iow io.Writer, serie string, timestamp int64) error {
+	name, tags := splitSerie(serie)
+
+	// models.MakeKey is the inverse of the models.ParseKey used in
+	// splitSerie, so it re-escapes measurement/tag spaces, commas and
+	// equals signs the way line protocol requires.
+	key := models.MakeKey([]byte(name), tags)
+	fmt.Fprintf(iow, "%s _old=true %d\n", key, timestamp)
+	return nil
+}
+
+// csvFormater renders a detected old serie as a CSV row with a stable
+// header: measurement,tags,max_timestamp
+type csvFormater struct {
+	wroteHeader bool
+}
+
+func (f *csvFormater) format(iow io.Writer, serie string, timestamp int64) error {
+	w := csv.NewWriter(iow)
+
+	if !f.wroteHeader {
+		if err := w.Write([]string{"measurement", "tags", "max_timestamp"}); err != nil {
+			return err
+		}
+		f.wroteHeader = true
+	}
+
+	name, tags := splitSerie(serie)
+	if err := w.Write([]string{name, tags.String(), strconv.FormatInt(timestamp, 10)}); err != nil {
+		return err
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// cborFormater renders a detected old serie as a CBOR-encoded map, one map
+// per serie written back to back with no separator, suitable for piping into
+// downstream Go tools without the overhead of JSON text.
+type cborFormater struct {
+}
+
+func (f *cborFormater) format(iow io.Writer, serie string, timestamp int64) error {
+	name, tags := splitSerie(serie)
+
+	data := map[string]interface{}{
+		"serie":       serie,
+		"timestamp":   timestamp,
+		"measurement": name,
+		"tags":        tags.Map(),
+	}
+
+	b, err := cbor.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = iow.Write(b)
+	return err
+}
+
 // OldSeriesRule defines a read-only rule to retrieve series that are oldest than a given timestamp
 type OldSeriesRule struct {
 	unixNano int64
-	out      io.Writer
 
-	series   map[string]int64
-	formater formater
+	hasUntil      bool
+	untilUnixNano int64
+
+	out io.Writer
+
+	// series holds the running max timestamp of every key seen so far,
+	// across every shard and TSM file in the scan. A given series key can
+	// reappear in any shard spanning its lifetime, so its max timestamp is
+	// only final once the whole scan has completed; touchedTSM only tracks
+	// which keys the TSM file currently being scanned touched, for
+	// progress reporting.
+	series     map[string]int64
+	touchedTSM map[string]bool
+	formater   formater
+
+	total   int
+	emitted int
+
+	stats     bool
+	startedAt time.Time
+	shards    int
+	bytes     int64
+
+	progress   progress.Reporter
+	currentTSM string
 
 	logger *log.Logger
 }
 
 // OldSerieRuleConfig represents the toml configuration for OldSerieRule
 type OldSerieRuleConfig struct {
+	output.OutputConfig
+
 	Time            string
-	Out             string
+	Until           string
 	Format          string
 	Timestamp       bool
 	TimestampLayout string
+	Stats           bool
+	Progress        string
+}
+
+var relativeDurationRegexp = regexp.MustCompile(`(\d+)(ms|us|ns|w|d|h|m|s)`)
+
+// parseRelativeDuration parses a Docker-style relative duration such as
+// "10m", "2h30m" or "7d"/"1w" into a time.Duration. The whole string must be
+// consumed by the (number, unit) groups it matches.
+func parseRelativeDuration(s string) (time.Duration, error) {
+	matches := relativeDurationRegexp.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("%q is not a relative duration", s)
+	}
+
+	var consumed strings.Builder
+	var d time.Duration
+	for _, m := range matches {
+		consumed.WriteString(m[0])
+
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+
+		switch m[2] {
+		case "w":
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case "d":
+			d += time.Duration(n) * 24 * time.Hour
+		default:
+			unit, err := time.ParseDuration("1" + m[2])
+			if err != nil {
+				return 0, err
+			}
+			d += time.Duration(n) * unit
+		}
+	}
+
+	if consumed.String() != s {
+		return 0, fmt.Errorf("%q is not a relative duration", s)
+	}
+	return d, nil
+}
+
+// parseTimestamp parses a time expression as one of: RFC3339, a Unix
+// timestamp in seconds (optionally with a fractional nanosecond component),
+// a Unix timestamp in nanoseconds, or a relative duration (interpreted as
+// "now minus duration", e.g. "10m", "2h30m", "7d", "1w").
+func parseTimestamp(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	if d, err := parseRelativeDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	if strings.Contains(s, ".") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			sec := int64(f)
+			nsec := int64((f - float64(sec)) * float64(time.Second))
+			return time.Unix(sec, nsec), nil
+		}
+	} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// A full-precision integer, parsed directly so that nanosecond
+		// timestamps (~19 digits) don't lose precision the way routing
+		// them through a float64 would.
+		if n > 1e12 {
+			return time.Unix(0, n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("%q is neither RFC3339, a unix timestamp nor a relative duration", s)
 }
 
 func newFormater(format string, withTimestamp bool, timestampLayout string) (formater, error) {
@@ -106,6 +366,12 @@ func newFormater(format string, withTimestamp bool, timestampLayout string) (for
 		return &textFormater{withTimestamp: withTimestamp, timestampLayout: timestampLayout}, nil
 	case "json":
 		return &jsonFormater{withTimestamp: withTimestamp, timestampLayout: timestampLayout}, nil
+	case "line":
+		return &lineFormater{}, nil
+	case "csv":
+		return &csvFormater{}, nil
+	case "cbor":
+		return &cborFormater{}, nil
 	default:
 		return nil, fmt.Errorf("Unknown format %s", format)
 	}
@@ -118,17 +384,28 @@ func NewOldSeriesRule(t time.Time, out io.Writer, format string) (*OldSeriesRule
 		return nil, err
 	}
 
-	return newOldSeriesRule(t, out, formater), nil
+	reporter, _ := progress.New("none", out)
+	return newOldSeriesRule(t, nil, out, formater, false, reporter), nil
 }
 
-func newOldSeriesRule(t time.Time, out io.Writer, formater formater) *OldSeriesRule {
-	return &OldSeriesRule{
-		unixNano: t.UnixNano() / int64(time.Nanosecond),
-		out:      out,
-		series:   make(map[string]int64),
-		formater: formater,
-		logger:   logging.GetLogger("OldSeriesRule"),
+func newOldSeriesRule(t time.Time, until *time.Time, out io.Writer, formater formater, stats bool, reporter progress.Reporter) *OldSeriesRule {
+	r := &OldSeriesRule{
+		unixNano:   t.UnixNano(),
+		out:        out,
+		series:     make(map[string]int64),
+		touchedTSM: make(map[string]bool),
+		formater:   formater,
+		stats:      stats,
+		progress:   reporter,
+		logger:     logging.GetLogger("OldSeriesRule"),
+	}
+
+	if until != nil {
+		r.hasUntil = true
+		r.untilUnixNano = until.UnixNano()
 	}
+
+	return r
 }
 
 // CheckMode sets the check mode on the rule
@@ -148,32 +425,60 @@ func (r *OldSeriesRule) WithLogger(logger *log.Logger) {
 
 // Start implements Rule interface
 func (r *OldSeriesRule) Start() {
+	r.startedAt = time.Now()
+}
 
+// isOld reports whether maxTs falls within the configured old-serie window.
+func (r *OldSeriesRule) isOld(maxTs int64) bool {
+	if r.hasUntil {
+		return maxTs >= r.unixNano && maxTs <= r.untilUnixNano
+	}
+	return maxTs <= r.unixNano
 }
 
-// End implements Rule interface
-func (r *OldSeriesRule) End() {
+// flush emits and forgets every key in r.series, in sorted order for
+// deterministic output.
+func (r *OldSeriesRule) flush() {
 	var keys []string
 	for k := range r.series {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
-	count := 0
-
 	for _, key := range keys {
 		maxTs := r.series[key]
-		if maxTs <= r.unixNano {
+		if r.isOld(maxTs) {
 			r.formater.format(r.out, key, maxTs)
-			count++
+			r.emitted++
+		}
+		delete(r.series, key)
+	}
+}
+
+// End implements Rule interface
+func (r *OldSeriesRule) End() {
+	r.flush()
+	r.progress.Finish()
+	r.logger.Printf("Detected %s/%s series as old", humanCount(r.emitted), humanCount(r.total))
+
+	if r.stats {
+		r.logger.Printf("Scanned %s across %d shard(s) in %s", humanBytes(r.bytes), r.shards, humanDuration(time.Since(r.startedAt)))
+	}
+
+	// Only sinks we resolved ourselves (via output.Build) are ours to close:
+	// asserting plain io.Closer would also match an *os.File handed to us
+	// by NewOldSeriesRule, e.g. os.Stdout, which we must never close.
+	if sink, ok := r.out.(output.Sink); ok {
+		if err := sink.Close(); err != nil {
+			r.logger.Printf("Error closing output: %s", err)
 		}
 	}
-	r.logger.Printf("Detected %d/%d series as old", count, len(keys))
 }
 
 // StartShard implements Rule interface
 func (r *OldSeriesRule) StartShard(info storage.ShardInfo) {
-
+	r.shards++
+	r.progress.StartShard(0)
 }
 
 // EndShard implements Rule interface
@@ -183,12 +488,17 @@ func (r *OldSeriesRule) EndShard() error {
 
 // StartTSM implements Rule interface
 func (r *OldSeriesRule) StartTSM(path string) {
+	r.touchedTSM = make(map[string]bool)
+	r.currentTSM = path
 
+	if info, err := os.Stat(path); err == nil {
+		r.bytes += info.Size()
+	}
 }
 
 // EndTSM implements Rule interface
 func (r *OldSeriesRule) EndTSM() {
-
+	r.progress.AdvanceTSM(r.currentTSM, len(r.touchedTSM))
 }
 
 // StartWAL implements Rule interface
@@ -213,7 +523,9 @@ func (r *OldSeriesRule) Apply(key []byte, values []tsm1.Value) ([]byte, []tsm1.V
 		}
 	} else {
 		r.series[s] = maxTs
+		r.total++
 	}
+	r.touchedTSM[s] = true
 
 	return nil, nil, nil
 }
@@ -227,33 +539,48 @@ func (c *OldSerieRuleConfig) Sample() string {
 	return `
 	[[rules.old-serie]]
 		time="2020-01-01 00:08:00"
+		# time=7d
+		# time=1609459200
+		# until="2020-02-01T00:00:00Z"
 		out=stdout
 		# out=out_file.log
+		# out=file:///var/log/old_series.log
+		# out=tcp://telegraf:8094
+		# out=s3://my-bucket/old_series.log
 		format=text
 		timestamp=true
+		# timestampLayout=human
 		# format=json
+		# format=line
+		# format=csv
+		# format=cbor
+		# stats=true
+		# progress=bar
+		# progress=json
 	`
 }
 
 // Build implements Config interface
 func (c *OldSerieRuleConfig) Build() (Rule, error) {
-	t, err := time.Parse(time.RFC3339, c.Time)
+	now := time.Now()
+
+	t, err := parseTimestamp(c.Time, now)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("time: %s", err)
 	}
 
-	var out io.Writer
-	if c.Out == "" {
-		out = os.Stdout
-	} else if c.Out == "stdout" {
-		out = os.Stdout
-	} else if c.Out == "stderr" {
-		out = os.Stderr
-	} else {
-		out, err = os.Create(c.Out)
+	var until *time.Time
+	if c.Until != "" {
+		u, err := parseTimestamp(c.Until, now)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("until: %s", err)
 		}
+		until = &u
+	}
+
+	sink, err := c.OutputConfig.Build()
+	if err != nil {
+		return nil, fmt.Errorf("out: %s", err)
 	}
 
 	format := "text"
@@ -266,5 +593,10 @@ func (c *OldSerieRuleConfig) Build() (Rule, error) {
 		return nil, err
 	}
 
-	return newOldSeriesRule(t, out, formater), nil
+	reporter, err := progress.New(c.Progress, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("progress: %s", err)
+	}
+
+	return newOldSeriesRule(t, until, sink, formater, c.Stats, reporter), nil
 }
@@ -0,0 +1,169 @@
+// Package output provides the destination sinks shared by read-only rules.
+// It exists so that every rule that needs to write its findings somewhere
+// (stdout, a file, a remote collector) does not have to re-implement the
+// same "out" TOML knob and io.Writer/URL resolution logic.
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Sink is a destination a rule can write its findings to. Most formaters
+// keep writing already-encoded bytes straight to a Sink via its embedded
+// io.Writer; Emit is there for sinks that would rather receive the
+// structured record and decide how to encode it themselves.
+type Sink interface {
+	io.Writer
+	// Emit writes a single structured record to the sink.
+	Emit(record map[string]interface{}) error
+	// Close flushes and releases any resource held by the sink.
+	Close() error
+}
+
+// OutputConfig is the TOML configuration shared by any rule that needs to
+// resolve an output destination. Embed it in a rule's own Config struct to
+// pick up the `out` knob.
+type OutputConfig struct {
+	Out string
+}
+
+// Build resolves the configured destination into a Sink. An empty Out
+// defaults to stdout.
+func (c *OutputConfig) Build() (Sink, error) {
+	return New(c.Out)
+}
+
+// New resolves a destination string into a Sink. It accepts the bare names
+// "stdout"/"stderr", a plain file path, or a URL: file://path, tcp://host:port
+// (for streaming line protocol into something like Telegraf) or
+// s3://bucket/key (for archival dumps, uploaded on Close).
+func New(out string) (Sink, error) {
+	switch out {
+	case "", "stdout":
+		return newWriterSink(os.Stdout, false), nil
+	case "stderr":
+		return newWriterSink(os.Stderr, false), nil
+	}
+
+	u, err := url.Parse(out)
+	if err != nil || u.Scheme == "" {
+		return newFileSink(out)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newFileSink(u.Path)
+	case "tcp":
+		return newTCPSink(u.Host)
+	case "s3":
+		return newS3Sink(u.Host, strings.TrimPrefix(u.Path, "/"))
+	default:
+		return nil, fmt.Errorf("unknown sink scheme %q in %q", u.Scheme, out)
+	}
+}
+
+// writerSink adapts a plain io.Writer into a Sink, falling back to
+// newline-delimited JSON for Emit.
+type writerSink struct {
+	w      io.Writer
+	closer io.Closer
+}
+
+func newWriterSink(w io.Writer, closable bool) *writerSink {
+	s := &writerSink{w: w}
+	if c, ok := w.(io.Closer); ok && closable {
+		s.closer = c
+	}
+	return s
+}
+
+func (s *writerSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *writerSink) Emit(record map[string]interface{}) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(s.w, string(b))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+func newFileSink(path string) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newWriterSink(f, true), nil
+}
+
+func newTCPSink(addr string) (Sink, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newWriterSink(conn, true), nil
+}
+
+// s3Sink buffers everything it's given and uploads it as a single object
+// on Close, for archival dumps of a rule's findings.
+type s3Sink struct {
+	bucket string
+	key    string
+	buf    bytes.Buffer
+}
+
+func newS3Sink(bucket, key string) (Sink, error) {
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("s3 sink requires a bucket and a key: s3://bucket/key")
+	}
+	return &s3Sink{bucket: bucket, key: key}, nil
+}
+
+func (s *s3Sink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *s3Sink) Emit(record map[string]interface{}) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = s.buf.Write(b)
+	return err
+}
+
+func (s *s3Sink) Close() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	uploader := s3manager.NewUploader(sess)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+		Body:   bytes.NewReader(s.buf.Bytes()),
+	})
+	return err
+}
@@ -0,0 +1,124 @@
+// Package progress provides feedback hooks for long-running rules that
+// otherwise stay silent until they finish scanning a shard.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+// Reporter is notified as a rule works its way through a shard's TSM
+// files, so a supervising process (or a human) gets feedback before the
+// rule's End() runs.
+type Reporter interface {
+	// StartShard is called once per shard, with the number of TSM files
+	// about to be scanned, or 0 if that count isn't known up front.
+	StartShard(total int)
+	// AdvanceTSM is called every time a TSM file has been fully scanned,
+	// with the number of keys it contributed.
+	AdvanceTSM(path string, keys int)
+	// Finish is called once scanning is complete.
+	Finish()
+}
+
+// New resolves a Reporter by kind: "bar" for a terminal progress bar
+// (falling back to plain lines when out is not a TTY), "json" for
+// newline-delimited JSON events suitable for a supervising process, or
+// "none" (the default) for no feedback at all.
+func New(kind string, out io.Writer) (Reporter, error) {
+	switch kind {
+	case "", "none":
+		return &noopReporter{}, nil
+	case "bar":
+		return newBarReporter(out), nil
+	case "json":
+		return &jsonReporter{out: out}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress reporter %q", kind)
+	}
+}
+
+type noopReporter struct{}
+
+func (r *noopReporter) StartShard(total int)          {}
+func (r *noopReporter) AdvanceTSM(path string, n int) {}
+func (r *noopReporter) Finish()                       {}
+
+// barReporter renders a carriage-return-driven progress bar when out is a
+// TTY, and one plain line per TSM file otherwise.
+type barReporter struct {
+	out   io.Writer
+	isTTY bool
+
+	total int
+	done  int
+	keys  int
+}
+
+func newBarReporter(out io.Writer) *barReporter {
+	isTTY := false
+	if f, ok := out.(*os.File); ok {
+		isTTY = term.IsTerminal(int(f.Fd()))
+	}
+	return &barReporter{out: out, isTTY: isTTY}
+}
+
+func (r *barReporter) StartShard(total int) {
+	r.total = total
+	r.done = 0
+	r.keys = 0
+}
+
+func (r *barReporter) AdvanceTSM(path string, keys int) {
+	r.done++
+	r.keys += keys
+
+	progress := fmt.Sprintf("%d", r.done)
+	if r.total > 0 {
+		progress = fmt.Sprintf("%d/%d", r.done, r.total)
+	}
+
+	if r.isTTY {
+		fmt.Fprintf(r.out, "\r[%s] %s (%d series)", progress, filepath.Base(path), r.keys)
+	} else {
+		fmt.Fprintf(r.out, "[%s] %s (%d series)\n", progress, filepath.Base(path), r.keys)
+	}
+}
+
+func (r *barReporter) Finish() {
+	if r.isTTY {
+		fmt.Fprintln(r.out)
+	}
+}
+
+// jsonReporter emits one JSON object per event, for a supervising process
+// to consume.
+type jsonReporter struct {
+	out io.Writer
+}
+
+func (r *jsonReporter) emit(event string, fields map[string]interface{}) {
+	fields["event"] = event
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(r.out, string(b))
+}
+
+func (r *jsonReporter) StartShard(total int) {
+	r.emit("start_shard", map[string]interface{}{"total": total})
+}
+
+func (r *jsonReporter) AdvanceTSM(path string, keys int) {
+	r.emit("advance_tsm", map[string]interface{}{"path": path, "keys": keys})
+}
+
+func (r *jsonReporter) Finish() {
+	r.emit("finish", map[string]interface{}{})
+}